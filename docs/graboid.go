@@ -3,6 +3,8 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,7 +19,10 @@ import (
 
 	"github.com/apex/log"
 	clihander "github.com/apex/log/handlers/cli"
+	"github.com/blacktop/graboid/pkg/archive"
+	"github.com/blacktop/graboid/pkg/reference"
 	"github.com/blacktop/graboid/pkg/registry"
+	"github.com/blacktop/graboid/pkg/trust"
 	"github.com/urfave/cli"
 )
 
@@ -35,13 +40,126 @@ var (
 	ImageName string
 	// ImageTag is the docker image tag to pull
 	ImageTag string
+	// ImageDigest is the content digest to pull by, set when the
+	// reference embeds one (e.g. "ubuntu@sha256:...")
+	ImageDigest string
 	// Proxy is the http/https proxy
 	Proxy string
+	// OSArg is the target platform OS used to select a manifest from a
+	// manifest list / OCI image index
+	OSArg string
+	// ArchArg is the target platform architecture used to select a
+	// manifest from a manifest list / OCI image index
+	ArchArg string
+	// VariantArg is the target platform variant (e.g. "v7" for arm)
+	VariantArg string
+	// Format is the output tarball layout: "docker" (default) or "oci"
+	Format string
+	// Trusted requires the pulled image to be resolved and verified
+	// through Docker Content Trust before it is downloaded
+	Trusted bool
+	// TrustRootFile pins the TUF trust root, overriding the on-disk cache
+	TrustRootFile string
+	// MaxConcurrentDownloads bounds how many layers are pulled at once
+	MaxConcurrentDownloads int
+	// CacheDir persists downloaded, verified layer blobs across pulls
+	CacheDir string
 	// creds
 	user   string
 	passwd string
 )
 
+func targetPlatform() registry.Platform {
+	return registry.Platform{OS: OSArg, Arch: ArchArg, Variant: VariantArg}
+}
+
+// setImageReference parses arg as a full image reference and populates
+// ImageName, ImageTag, ImageDigest and, when the reference embeds a
+// registry host, RegistryDomain/IndexDomain.
+func setImageReference(arg string) error {
+	ref, err := reference.Parse(arg)
+	if err != nil {
+		return err
+	}
+
+	if ref.Domain != "" {
+		IndexDomain = "https://" + ref.Domain
+		RegistryDomain = "https://" + ref.Domain
+	}
+
+	ImageName = ref.Name
+	// A bare name (no slash) only expands into the "library/" namespace
+	// when the *effective* registry - after any --registry/--index
+	// override - is actually Docker Hub. A reference with no embedded
+	// domain pulled against a private registry must not be rewritten.
+	if !strings.Contains(ImageName, "/") && IndexDomain == defaultIndexDomain {
+		ImageName = "library/" + ImageName
+	}
+	ImageTag = ref.Tag
+	ImageDigest = ref.Digest
+	if ImageTag == "" && ImageDigest == "" {
+		ImageTag = "latest"
+	}
+	return nil
+}
+
+// defaultNotaryServer is the trust server Docker Hub publishes signed
+// metadata to.
+const defaultNotaryServer = "https://notary.docker.io"
+
+// defaultCacheDir is $XDG_CACHE_HOME/graboid (falling back to
+// ~/.cache/graboid), via os.UserCacheDir.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "graboid")
+}
+
+// defaultIndexDomain is the auth-index host Docker Hub pulls are made
+// against, and the cli flag's default for --index.
+const defaultIndexDomain = "https://index.docker.io"
+
+func trustServer() string {
+	if IndexDomain == defaultIndexDomain {
+		return defaultNotaryServer
+	}
+	return IndexDomain
+}
+
+// trustGUN builds the TUF "global unique name" for the current image:
+// <domain>/<repo>. Docker Hub's trust metadata is rooted at its canonical
+// domain (reference.DefaultDomain, "docker.io"), not at the auth-index
+// host (index.docker.io) Hub pulls otherwise go through.
+func trustGUN() string {
+	host := strings.TrimPrefix(strings.TrimPrefix(IndexDomain, "https://"), "http://")
+	if IndexDomain == defaultIndexDomain {
+		host = reference.DefaultDomain
+	}
+	return host + "/" + ImageName
+}
+
+// resolveTrustedDigest looks up ImageName:ImageTag's signed manifest
+// digest and size via Docker Content Trust, so the pull can be rewritten
+// to fetch by digest.
+func resolveTrustedDigest(insecure bool) (digest string, size int64, err error) {
+	cacheDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", 0, err
+	}
+	cacheDir = filepath.Join(cacheDir, ".graboid", "trust")
+
+	client := trust.New(trust.Config{
+		Server:   trustServer(),
+		RootFile: TrustRootFile,
+		CacheDir: cacheDir,
+		Insecure: insecure,
+	})
+
+	return client.Resolve(trustGUN(), ImageTag)
+}
+
 // Manifest docker image manifest
 type Manifest struct {
 	Config   string
@@ -64,13 +182,15 @@ func getFmtStr() string {
 
 func initRegistry(reposName string, insecure bool) *registry.Registry {
 	config := registry.Config{
-		Endpoint:       IndexDomain,
-		RegistryDomain: RegistryDomain,
-		Proxy:          Proxy,
-		Insecure:       insecure,
-		RepoName:       reposName,
-		Username:       user,
-		Password:       passwd,
+		Endpoint:               IndexDomain,
+		RegistryDomain:         RegistryDomain,
+		Proxy:                  Proxy,
+		Insecure:               insecure,
+		RepoName:               reposName,
+		Username:               user,
+		Password:               passwd,
+		MaxConcurrentDownloads: MaxConcurrentDownloads,
+		CacheDir:               CacheDir,
 	}
 	registry, err := registry.New(config)
 	if err != nil {
@@ -133,6 +253,102 @@ func createManifest(tempDir, confFile string, layerFiles []string) (string, erro
 	return tmpfn, nil
 }
 
+// ociLayout is the content of the "oci-layout" marker file required at the
+// root of every OCI image layout.
+const ociLayout = `{"imageLayoutVersion":"1.0.0"}`
+
+// ociDescriptor mirrors registry.Descriptor for the purposes of writing an
+// OCI image-layout index.json/manifest.json.
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// createOCILayout lays out tempDir as an OCI image layout: oci-layout,
+// index.json pointing at an OCI manifest rebuilt from the resolved one, and
+// blobs/sha256/<digest> for that manifest, the config and every layer.
+func createOCILayout(tempDir string, mF *registry.Manifest, cfile string, lfiles []string) error {
+	blobsDir := filepath.Join(tempDir, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return err
+	}
+
+	move := func(name string, digest string) (ociDescriptor, error) {
+		src := filepath.Join(tempDir, name)
+		info, err := os.Stat(src)
+		if err != nil {
+			return ociDescriptor{}, err
+		}
+		dst := filepath.Join(blobsDir, strings.TrimPrefix(digest, "sha256:"))
+		if err := os.Rename(src, dst); err != nil {
+			return ociDescriptor{}, err
+		}
+		return ociDescriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digest, Size: info.Size()}, nil
+	}
+
+	var layers []ociDescriptor
+	for i, lf := range lfiles {
+		d, err := move(lf, "sha256:"+strings.TrimSuffix(lf, ".tar.gz"))
+		if err != nil {
+			return fmt.Errorf("laying out layer %d: %v", i, err)
+		}
+		layers = append(layers, d)
+	}
+
+	config, err := move(cfile, mF.Config.Digest)
+	if err != nil {
+		return fmt.Errorf("laying out config: %v", err)
+	}
+	config.MediaType = "application/vnd.oci.image.config.v1+json"
+
+	manifest := struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType"`
+		Config        ociDescriptor   `json:"config"`
+		Layers        []ociDescriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config:        config,
+		Layers:        layers,
+	}
+	mJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestDigest := sha256Hex(mJSON)
+	if err := os.WriteFile(filepath.Join(blobsDir, manifestDigest), mJSON, 0644); err != nil {
+		return err
+	}
+
+	index := struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		Manifests     []ociDescriptor `json:"manifests"`
+	}{
+		SchemaVersion: 2,
+		Manifests: []ociDescriptor{{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    "sha256:" + manifestDigest,
+			Size:      int64(len(mJSON)),
+		}},
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "index.json"), indexJSON, 0644); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(tempDir, "oci-layout"), []byte(ociLayout), 0644)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
 func tarFiles(srcDir, tarName string) error {
 	tarfile, err := os.Create(tarName)
 	if err != nil {
@@ -180,11 +396,43 @@ func DownloadImage(insecure bool) {
 	ctx.Infof(getFmtStr(), "Initialize Registry")
 	registry := initRegistry(ImageName, insecure)
 
-	mF, err := registry.ReposManifests(ImageName, ImageTag)
+	pullRef := ImageTag
+	if ImageDigest != "" {
+		pullRef = ImageDigest
+	}
+	var trustedDigest string
+	var trustedSize int64
+	if Trusted {
+		log.Infof(getFmtStr(), "VERIFY content trust")
+		digest, size, err := resolveTrustedDigest(insecure)
+		if err != nil {
+			ctx.Fatal(err.Error())
+		}
+		pullRef, trustedDigest, trustedSize = digest, digest, size
+	}
+
+	mF, err := registry.ReposManifests(ImageName, pullRef, targetPlatform())
 	if err != nil {
 		ctx.Fatal(err.Error())
 	}
 
+	if Trusted {
+		if mF.Digest != trustedDigest {
+			ctx.Fatal(fmt.Sprintf("pulled manifest digest %s does not match trusted digest %s", mF.Digest, trustedDigest))
+		}
+		if mF.Size != trustedSize {
+			ctx.Fatal(fmt.Sprintf("pulled manifest size %d does not match trusted size %d", mF.Size, trustedSize))
+		}
+	} else if ImageDigest != "" {
+		if mF.Digest != ImageDigest {
+			ctx.Fatal(fmt.Sprintf("pulled manifest digest %s does not match requested digest %s", mF.Digest, ImageDigest))
+		}
+	}
+
+	if Format == "oci" && mF.Config.Digest == "" {
+		ctx.Fatal(fmt.Sprintf("%s:%s only has a legacy schema 1 manifest with no config blob; --format oci requires a schema 2 or OCI manifest", ImageName, pullRef))
+	}
+
 	dir, err := ioutil.TempDir("", "graboid")
 	if err != nil {
 		ctx.Fatal(err.Error())
@@ -203,10 +451,16 @@ func DownloadImage(insecure bool) {
 		ctx.Fatal(err.Error())
 	}
 
-	log.Infof(getFmtStr(), "CREATE manifest.json")
-	_, err = createManifest(dir, cfile, lfiles)
-	if err != nil {
-		ctx.Fatal(err.Error())
+	if Format == "oci" {
+		log.Infof(getFmtStr(), "CREATE OCI layout")
+		if err := createOCILayout(dir, mF, cfile, lfiles); err != nil {
+			ctx.Fatal(err.Error())
+		}
+	} else {
+		log.Infof(getFmtStr(), "CREATE manifest.json")
+		if _, err := createManifest(dir, cfile, lfiles); err != nil {
+			ctx.Fatal(err.Error())
+		}
 	}
 
 	tarFile := fmt.Sprintf("%s.tar", strings.Replace(ImageName, "/", "_", 1))
@@ -222,6 +476,77 @@ func DownloadImage(insecure bool) {
 	log.Infof("\033[1mSUCCESS!\033[0m")
 }
 
+// CmdExtract pulls paths out of an image's merged rootfs (or a single
+// layer) without needing a running Docker daemon.
+func CmdExtract(insecure bool, paths []string, outputDir string, asTar bool, layerNum int) error {
+	ctx.Infof(getFmtStr(), "Initialize Registry")
+	registry := initRegistry(ImageName, insecure)
+
+	pullRef := ImageTag
+	if ImageDigest != "" {
+		pullRef = ImageDigest
+	}
+
+	mF, err := registry.ReposManifests(ImageName, pullRef, targetPlatform())
+	if err != nil {
+		return err
+	}
+	if ImageDigest != "" && mF.Digest != ImageDigest {
+		return fmt.Errorf("pulled manifest digest %s does not match requested digest %s", mF.Digest, ImageDigest)
+	}
+
+	dir, err := ioutil.TempDir("", "graboid")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir) // clean up
+
+	log.Infof(getFmtStr(), "GET LAYERS")
+	lfiles, err := registry.RepoGetLayers(dir, ImageName, mF)
+	if err != nil {
+		return err
+	}
+
+	if layerNum > 0 {
+		if layerNum > len(lfiles) {
+			return fmt.Errorf("image only has %d layers", len(lfiles))
+		}
+		// layerNum is 1-indexed; extract that layer's own tar contents
+		// only, not the merged view of it and every layer before it.
+		lfiles = lfiles[layerNum-1 : layerNum]
+	}
+
+	idx, err := archive.BuildIndex(dir, lfiles)
+	if err != nil {
+		return err
+	}
+
+	var entries []archive.Entry
+	for _, p := range paths {
+		matches := idx.Lookup(p)
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: not found in image", p)
+		}
+		entries = append(entries, matches...)
+	}
+
+	if asTar {
+		return archive.WriteTar(dir, entries, os.Stdout)
+	}
+
+	if outputDir == "" {
+		outputDir = "."
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	if err := archive.ExtractTo(dir, outputDir, entries); err != nil {
+		return err
+	}
+	log.Infof("\033[1mSUCCESS!\033[0m")
+	return nil
+}
+
 var appHelpTemplate = `Usage: {{.Name}} {{if .Flags}}[OPTIONS] {{end}}COMMAND [arg...]
 
 {{.Usage}}
@@ -257,7 +582,7 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:        "index",
-			Value:       "https://index.docker.io",
+			Value:       defaultIndexDomain,
 			Usage:       "override index endpoint",
 			EnvVar:      "GRABOID_INDEX",
 			Destination: &IndexDomain,
@@ -294,6 +619,55 @@ func main() {
 			EnvVar:      "GRABOID_PASSWORD",
 			Destination: &passwd,
 		},
+		cli.StringFlag{
+			Name:        "os",
+			Value:       runtime.GOOS,
+			Usage:       "platform OS to select from a manifest list/image index",
+			Destination: &OSArg,
+		},
+		cli.StringFlag{
+			Name:        "arch",
+			Value:       runtime.GOARCH,
+			Usage:       "platform architecture to select from a manifest list/image index",
+			Destination: &ArchArg,
+		},
+		cli.StringFlag{
+			Name:        "variant",
+			Value:       "",
+			Usage:       "platform variant to select from a manifest list/image index",
+			Destination: &VariantArg,
+		},
+		cli.StringFlag{
+			Name:        "format",
+			Value:       "docker",
+			Usage:       "output tarball layout: docker or oci",
+			Destination: &Format,
+		},
+		cli.BoolFlag{
+			Name:        "trusted",
+			Usage:       "require and verify Docker Content Trust signatures before pulling",
+			EnvVar:      "GRABOID_CONTENT_TRUST",
+			Destination: &Trusted,
+		},
+		cli.StringFlag{
+			Name:        "trust-root",
+			Value:       "",
+			Usage:       "pin the TUF trust root to this file instead of ~/.graboid/trust/<registry>/<repo>/root.json",
+			Destination: &TrustRootFile,
+		},
+		cli.IntFlag{
+			Name:        "max-concurrent-downloads",
+			Value:       3,
+			Usage:       "number of layers to download at once",
+			Destination: &MaxConcurrentDownloads,
+		},
+		cli.StringFlag{
+			Name:        "cache-dir",
+			Value:       defaultCacheDir(),
+			Usage:       "directory used to cache downloaded, verified layers across pulls",
+			EnvVar:      "GRABOID_CACHE_DIR",
+			Destination: &CacheDir,
+		},
 	}
 	app.Commands = []cli.Command{
 		{
@@ -305,13 +679,8 @@ func main() {
 				}
 
 				if c.Args().Present() {
-					if strings.Contains(c.Args().First(), ":") {
-						imageParts := strings.Split(c.Args().First(), ":")
-						ImageName = imageParts[0]
-						ImageTag = imageParts[1]
-					} else {
-						ImageName = c.Args().First()
-						ImageTag = "latest"
+					if err := setImageReference(c.Args().First()); err != nil {
+						return err
 					}
 
 					ctx = log.WithFields(log.Fields{
@@ -325,17 +694,45 @@ func main() {
 			},
 		},
 		{
-			Name:  "extract",
-			Usage: "Extract files from images",
+			Name:      "extract",
+			Usage:     "Extract files from images",
+			ArgsUsage: "image:tag PATH [PATH...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "directory to write extracted paths to",
+				},
+				cli.BoolFlag{
+					Name:  "tar",
+					Usage: "write extracted paths as a tarball to stdout",
+				},
+				cli.IntFlag{
+					Name:  "layer",
+					Usage: "extract from a single layer (1-indexed, oldest first) instead of the merged rootfs",
+				},
+			},
 			Action: func(c *cli.Context) error {
 
 				if c.Bool("verbose") {
 					log.SetLevel(log.DebugLevel)
 				}
 
-				log.Error("this has not been implimented yet")
+				args := c.Args()
+				if len(args) < 2 {
+					return errors.New("please supply a image:tag and at least one path to extract")
+				}
 
-				return nil
+				if err := setImageReference(args[0]); err != nil {
+					return err
+				}
+
+				ctx = log.WithFields(log.Fields{
+					"domain": IndexDomain,
+					"image":  ImageName,
+					"tag":    ImageTag,
+				})
+
+				return CmdExtract(c.Bool("insecure"), args[1:], c.String("output"), c.Bool("tar"), c.Int("layer"))
 			},
 		},
 	}
@@ -346,18 +743,8 @@ func main() {
 		}
 
 		if c.Args().Present() {
-			if strings.Contains(c.Args().First(), ":") {
-				imageParts := strings.Split(c.Args().First(), ":")
-				ImageName = imageParts[0]
-				ImageTag = imageParts[1]
-			} else {
-				ImageName = c.Args().First()
-				ImageTag = "latest"
-			}
-
-			// test for official image name
-			if !strings.Contains(ImageName, "/") {
-				ImageName = "library/" + ImageName
+			if err := setImageReference(c.Args().First()); err != nil {
+				return err
 			}
 
 			ctx = log.WithFields(log.Fields{
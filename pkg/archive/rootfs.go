@@ -0,0 +1,317 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// Entry is a single path in the flattened rootfs view, pointing back at the
+// layer tarball it was last written in.
+type Entry struct {
+	Header     *tar.Header
+	LayerFile  string
+	LayerIndex int
+}
+
+// Index maps a cleaned, slash-rooted path to the entry that wins after all
+// layers are applied.
+type Index map[string]Entry
+
+// BuildIndex walks layerFiles, oldest first, and returns the flattened
+// rootfs view after applying whiteout and opaque-directory semantics.
+func BuildIndex(dir string, layerFiles []string) (Index, error) {
+	idx := make(Index)
+	for i, lf := range layerFiles {
+		if err := applyLayer(idx, filepath.Join(dir, lf), lf, i); err != nil {
+			return nil, fmt.Errorf("applying layer %s: %v", lf, err)
+		}
+	}
+	return idx, nil
+}
+
+func applyLayer(idx Index, layerPath, layerFile string, layerIndex int) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	var opaqueDirs []string
+	var removals []string
+	var adds []*tar.Header
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := cleanPath(hdr.Name)
+		base := path.Base(name)
+		dir := path.Dir(name)
+
+		switch {
+		case base == whiteoutOpaqueDir:
+			opaqueDirs = append(opaqueDirs, dir)
+		case isWhiteout(base):
+			removals = append(removals, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			hdrCopy := *hdr
+			hdrCopy.Name = name
+			adds = append(adds, &hdrCopy)
+		}
+	}
+
+	// Whiteouts and opaque markers always hide content from layers
+	// below them, regardless of their physical order in the tar.
+	for _, d := range opaqueDirs {
+		removeSubtree(idx, d)
+	}
+	for _, r := range removals {
+		removeSubtree(idx, r)
+		delete(idx, r)
+	}
+	for _, hdr := range adds {
+		idx[hdr.Name] = Entry{Header: hdr, LayerFile: layerFile, LayerIndex: layerIndex}
+	}
+
+	return nil
+}
+
+func removeSubtree(idx Index, dir string) {
+	prefix := dir
+	if prefix != "/" {
+		prefix += "/"
+	}
+	for p := range idx {
+		if p == dir || strings.HasPrefix(p, prefix) {
+			delete(idx, p)
+		}
+	}
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
+
+// Lookup returns every index entry at, or below, requestedPath.
+func (idx Index) Lookup(requestedPath string) []Entry {
+	target := cleanPath(requestedPath)
+	var matches []Entry
+	if e, ok := idx[target]; ok {
+		matches = append(matches, e)
+	}
+	prefix := target
+	if prefix != "/" {
+		prefix += "/"
+	}
+	for p, e := range idx {
+		if p != target && strings.HasPrefix(p, prefix) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
+
+// ExtractTo writes entries out under destDir, each at its full path in the
+// image (e.g. /etc/passwd lands at destDir/etc/passwd).
+func ExtractTo(dir, destDir string, entries []Entry) error {
+	for _, e := range entries {
+		if err := writeEntry(dir, destDir, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEntry(dir, destDir string, e Entry) error {
+	// Resolve the entry's parent directory the way containerd's layer
+	// applier does: walk it component by component, following any
+	// symlinks an earlier (malicious) entry may have planted, but
+	// clamping the walk to destDir so a symlink like "foo -> /etc" can't
+	// be used to smuggle a later "foo/pwned" entry outside destDir.
+	parent, err := safeJoin(destDir, path.Dir(e.Header.Name))
+	if err != nil {
+		return fmt.Errorf("resolving %s: %v", e.Header.Name, err)
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(parent, path.Base(e.Header.Name))
+
+	switch e.Header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0755)
+	case tar.TypeSymlink:
+		os.Remove(target)
+		return os.Symlink(e.Header.Linkname, target)
+	default:
+		log.WithField("path", e.Header.Name).Debug("extracting file")
+		return copyFromLayer(dir, e, target)
+	}
+}
+
+// safeJoin resolves rel against root, evaluating every symlink
+// encountered along the way and clamping any symlink target (or "..")
+// that would otherwise escape back above root. This is the same
+// approach containerd/moby use when applying untrusted layer tars, and
+// stops a layer entry from using a symlink to write outside root.
+func safeJoin(root, rel string) (string, error) {
+	const maxLinks = 255
+
+	current := root
+	remaining := rel
+	links := 0
+
+	for remaining != "" {
+		i := strings.IndexByte(remaining, '/')
+		var component string
+		if i < 0 {
+			component, remaining = remaining, ""
+		} else {
+			component, remaining = remaining[:i], remaining[i+1:]
+		}
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if current != root {
+				current = filepath.Dir(current)
+			}
+			continue
+		}
+
+		next := filepath.Join(current, component)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			if os.IsNotExist(err) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", fmt.Errorf("too many levels of symbolic links resolving %s", rel)
+		}
+		linkTarget, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(linkTarget) {
+			current = root
+		}
+		remaining = linkTarget + "/" + remaining
+	}
+
+	return current, nil
+}
+
+func copyFromLayer(dir string, e Entry, target string) error {
+	f, err := os.Open(filepath.Join(dir, e.LayerFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("entry %s not found in layer %s", e.Header.Name, e.LayerFile)
+		}
+		if err != nil {
+			return err
+		}
+		if cleanPath(hdr.Name) != e.Header.Name {
+			continue
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, tr)
+		return err
+	}
+}
+
+// WriteTar streams entries as a tar archive to w, reading file content from
+// their originating layer.
+func WriteTar(dir string, entries []Entry, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.Header); err != nil {
+			return err
+		}
+		if e.Header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := copyEntryContent(dir, e, tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyEntryContent(dir string, e Entry, w io.Writer) error {
+	f, err := os.Open(filepath.Join(dir, e.LayerFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("entry %s not found in layer %s", e.Header.Name, e.LayerFile)
+		}
+		if err != nil {
+			return err
+		}
+		if cleanPath(hdr.Name) != e.Header.Name {
+			continue
+		}
+		_, err = io.Copy(w, tr)
+		return err
+	}
+}
@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	Header  tar.Header
+	Content []byte
+}
+
+func writeLayer(t *testing.T, dir, name string, entries []tarEntry) string {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for _, e := range entries {
+		hdr := e.Header
+		hdr.Size = int64(len(e.Content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if len(e.Content) > 0 {
+			if _, err := tw.Write(e.Content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return name
+}
+
+func reg(name string) tarEntry {
+	return tarEntry{Header: tar.Header{Name: name, Typeflag: tar.TypeReg}}
+}
+
+func TestBuildIndexWhiteout(t *testing.T) {
+	dir := t.TempDir()
+
+	layer0 := writeLayer(t, dir, "layer0.tar.gz", []tarEntry{
+		reg("a/file1"),
+		reg("a/file2"),
+	})
+	layer1 := writeLayer(t, dir, "layer1.tar.gz", []tarEntry{
+		reg("a/.wh.file1"),
+		reg("a/file3"),
+	})
+
+	idx, err := BuildIndex(dir, []string{layer0, layer1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := idx["/a/file1"]; ok {
+		t.Error("whited-out /a/file1 should not be in the index")
+	}
+	if _, ok := idx["/a/file2"]; !ok {
+		t.Error("/a/file2 from layer0 should still be in the index")
+	}
+	if _, ok := idx["/a/file3"]; !ok {
+		t.Error("/a/file3 from layer1 should be in the index")
+	}
+}
+
+func TestBuildIndexOpaqueDir(t *testing.T) {
+	dir := t.TempDir()
+
+	layer0 := writeLayer(t, dir, "layer0.tar.gz", []tarEntry{
+		reg("a/file1"),
+	})
+	layer1 := writeLayer(t, dir, "layer1.tar.gz", []tarEntry{
+		{Header: tar.Header{Name: "a/.wh..wh..opq", Typeflag: tar.TypeReg}},
+		reg("a/file2"),
+	})
+
+	idx, err := BuildIndex(dir, []string{layer0, layer1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := idx["/a/file1"]; ok {
+		t.Error("/a/file1 should be hidden by the opaque marker in layer1")
+	}
+	if _, ok := idx["/a/file2"]; !ok {
+		t.Error("/a/file2 added alongside the opaque marker should remain")
+	}
+}
+
+func TestLookupRoot(t *testing.T) {
+	idx := Index{
+		"/etc/passwd": Entry{Header: &tar.Header{Name: "/etc/passwd"}},
+		"/bin/sh":     Entry{Header: &tar.Header{Name: "/bin/sh"}},
+	}
+
+	matches := idx.Lookup("/")
+	if len(matches) != 2 {
+		t.Fatalf("Lookup(\"/\") = %d entries, want 2", len(matches))
+	}
+}
+
+func TestExtractToClampsSymlinkEscape(t *testing.T) {
+	layerDir := t.TempDir()
+	destDir := t.TempDir()
+	outside := t.TempDir()
+
+	// A prior layer entry planted a symlink in destDir that points
+	// outside of it.
+	if err := os.Symlink(outside, filepath.Join(destDir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	layer := writeLayer(t, layerDir, "layer0.tar.gz", []tarEntry{
+		{Header: tar.Header{Name: "foo/pwned", Typeflag: tar.TypeReg}, Content: []byte("pwned")},
+	})
+
+	idx, err := BuildIndex(layerDir, []string{layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := idx.Lookup("/foo/pwned")
+	if len(entries) != 1 {
+		t.Fatalf("Lookup(\"/foo/pwned\") = %d entries, want 1", len(entries))
+	}
+
+	if err := ExtractTo(layerDir, destDir, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+		t.Fatal("file escaped through the symlink into the directory outside destDir")
+	}
+
+	// The absolute symlink target is re-rooted under destDir rather than
+	// followed for real, so the file should land at destDir+outside/pwned.
+	clamped := filepath.Join(destDir, outside, "pwned")
+	if _, err := os.Stat(clamped); err != nil {
+		t.Fatalf("expected the file to land under destDir at %s: %v", clamped, err)
+	}
+}
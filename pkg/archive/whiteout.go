@@ -0,0 +1,19 @@
+// Package archive flattens a sequence of OCI/Docker image layer tarballs
+// into a single rootfs view, honoring AUFS-style whiteout files, so that
+// individual paths can be extracted without a running Docker daemon.
+package archive
+
+const (
+	// whiteoutPrefix marks a regular whiteout: a file named
+	// ".wh.<name>" in a directory means "<name>" is deleted in this
+	// layer and must not be visible in layers below.
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaqueDir marks a directory as opaque: every entry
+	// contributed by layers below it is hidden, only entries from this
+	// layer (and above) remain visible.
+	whiteoutOpaqueDir = ".wh..wh..opq"
+)
+
+func isWhiteout(base string) bool {
+	return len(base) > len(whiteoutPrefix) && base[:len(whiteoutPrefix)] == whiteoutPrefix
+}
@@ -0,0 +1,128 @@
+// Package progress renders a set of concurrently-updating progress bars
+// to a terminal, one line per bar, similar to `docker pull`'s per-layer
+// download output.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Bar tracks the progress of a single download.
+type Bar struct {
+	id string
+
+	mu      sync.Mutex
+	current int64
+	total   int64
+	status  string
+}
+
+// SetTotal records the expected final size, once known (e.g. once the
+// response's Content-Length is read).
+func (b *Bar) SetTotal(total int64) {
+	b.mu.Lock()
+	b.total = total
+	b.mu.Unlock()
+}
+
+// Add advances the bar by n bytes.
+func (b *Bar) Add(n int64) {
+	b.mu.Lock()
+	b.current += n
+	b.mu.Unlock()
+}
+
+// Done marks the bar with a final status line, e.g. "Download complete".
+func (b *Bar) Done(status string) {
+	b.mu.Lock()
+	b.status = status
+	b.mu.Unlock()
+}
+
+func (b *Bar) render(width int) string {
+	b.mu.Lock()
+	current, total, status := b.current, b.total, b.status
+	b.mu.Unlock()
+
+	if status != "" {
+		return fmt.Sprintf("%s: %s", b.id, status)
+	}
+	if total <= 0 {
+		return fmt.Sprintf("%s: %s", b.id, humanBytes(current))
+	}
+
+	filled := int(float64(width) * float64(current) / float64(total))
+	if filled > width {
+		filled = width
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	return fmt.Sprintf("%s: [%s] %s/%s", b.id, bar, humanBytes(current), humanBytes(total))
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Set is a collection of bars rendered together, each on its own
+// terminal line, redrawn in place as they're updated.
+type Set struct {
+	mu       sync.Mutex
+	out      io.Writer
+	bars     []*Bar
+	barWidth int
+	drawn    int
+}
+
+// NewSet returns a Set that renders to out.
+func NewSet(out io.Writer) *Set {
+	return &Set{out: out, barWidth: 30}
+}
+
+// NewBar registers a new bar with the given id and (possibly unknown,
+// zero) total size, and returns it for the caller to update.
+func (s *Set) NewBar(id string, total int64) *Bar {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &Bar{id: id, total: total}
+	s.bars = append(s.bars, b)
+	s.draw()
+	return b
+}
+
+// Draw redraws every bar's current state in place.
+func (s *Set) Draw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draw()
+}
+
+func (s *Set) draw() {
+	if s.drawn > 0 {
+		fmt.Fprintf(s.out, "\033[%dA", s.drawn)
+	}
+	for _, b := range s.bars {
+		fmt.Fprintf(s.out, "\033[2K%s\n", b.render(s.barWidth))
+	}
+	s.drawn = len(s.bars)
+}
+
+// Close performs one final redraw and stops updating in place, so
+// whatever is printed next starts on a fresh line.
+func (s *Set) Close() {
+	s.Draw()
+	s.mu.Lock()
+	s.drawn = 0
+	s.mu.Unlock()
+}
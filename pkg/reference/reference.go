@@ -0,0 +1,119 @@
+// Package reference parses Docker/OCI image references of the form
+// [registry[:port]/]name[:tag][@digest] into their component parts.
+package reference
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultDomain is the registry assumed when a reference names no
+// explicit host, and the only domain under which bare names are expanded
+// into the "library/" namespace.
+const DefaultDomain = "docker.io"
+
+// Reference is a parsed image reference. Domain is empty when the
+// reference named no explicit registry; Tag and Digest are empty when
+// the reference did not specify one.
+type Reference struct {
+	Domain string
+	Name   string
+	Tag    string
+	Digest string
+}
+
+// Parse splits s into its registry domain, repository name, tag and
+// digest components. It does not apply any defaulting (DefaultDomain,
+// the "latest" tag, or the "library/" namespace) beyond what the
+// "name[:tag][@digest]" grammar requires to disambiguate a domain from a
+// path component: callers that need defaults apply them explicitly.
+//
+// In particular, Parse does NOT expand a bare name into "library/name":
+// that default only applies when the reference is being resolved
+// against Docker Hub, which Parse - given only the reference string -
+// has no way to know. A caller that has already applied a --registry/
+// --index override needs to make that decision itself, using the
+// *effective* registry rather than whether this one reference happened
+// to name a domain.
+func Parse(s string) (*Reference, error) {
+	if s == "" {
+		return nil, fmt.Errorf("reference must not be empty")
+	}
+
+	remainder, digest, err := splitDigest(s)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, name, tag, err := splitDomainNameTag(remainder)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%s: missing repository name", s)
+	}
+
+	return &Reference{Domain: domain, Name: name, Tag: tag, Digest: digest}, nil
+}
+
+func splitDigest(s string) (remainder, digest string, err error) {
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return s, "", nil
+	}
+	remainder, digest = s[:at], s[at+1:]
+	if remainder == "" {
+		return "", "", fmt.Errorf("%s: missing repository name before '@'", s)
+	}
+	if !isDigest(digest) {
+		return "", "", fmt.Errorf("%s: invalid digest %q", s, digest)
+	}
+	return remainder, digest, nil
+}
+
+func isDigest(s string) bool {
+	colon := strings.Index(s, ":")
+	if colon <= 0 || colon == len(s)-1 {
+		return false
+	}
+	hex := s[colon+1:]
+	if len(hex) < 32 {
+		return false
+	}
+	for _, c := range hex {
+		if !isHex(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c rune) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func splitDomainNameTag(s string) (domain, name, tag string, err error) {
+	nameAndTag := s
+	if slash := strings.Index(s, "/"); slash > 0 && looksLikeDomain(s[:slash]) {
+		domain = s[:slash]
+		nameAndTag = s[slash+1:]
+	}
+
+	// A colon after the last slash is the tag separator; one before it
+	// belongs to the domain's port, which has already been split off.
+	name = nameAndTag
+	if i := strings.LastIndex(nameAndTag, ":"); i >= 0 && !strings.Contains(nameAndTag[i:], "/") {
+		name, tag = nameAndTag[:i], nameAndTag[i+1:]
+		if tag == "" {
+			return "", "", "", fmt.Errorf("%s: missing tag after ':'", s)
+		}
+	}
+	return domain, name, tag, nil
+}
+
+// looksLikeDomain reports whether s is a registry host rather than the
+// first path component of a repository name, using the same heuristic as
+// docker/distribution: it must contain a '.' or ':', or be "localhost".
+func looksLikeDomain(s string) bool {
+	return s == "localhost" || strings.ContainsAny(s, ".:")
+}
@@ -0,0 +1,63 @@
+package reference
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Reference
+	}{
+		{"ubuntu", Reference{Name: "ubuntu"}},
+		{"ubuntu:18.04", Reference{Name: "ubuntu", Tag: "18.04"}},
+		{"library/ubuntu", Reference{Name: "library/ubuntu"}},
+		{"myregistry.example.com/foo/bar:latest", Reference{Domain: "myregistry.example.com", Name: "foo/bar", Tag: "latest"}},
+		{"localhost:5000/foo", Reference{Domain: "localhost:5000", Name: "foo"}},
+		{"myregistry.example.com:5000/foo:v1", Reference{Domain: "myregistry.example.com:5000", Name: "foo", Tag: "v1"}},
+		{"ubuntu@sha256:" + digest64, Reference{Name: "ubuntu", Digest: "sha256:" + digest64}},
+		{"foo/bar", Reference{Name: "foo/bar"}},
+	}
+
+	for _, c := range cases {
+		got, err := Parse(c.in)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", c.in, err)
+			continue
+		}
+		if *got != c.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", c.in, *got, c.want)
+		}
+	}
+}
+
+// digest64 is a syntactically valid (if not cryptographically real)
+// sha256 hex digest for use in test fixtures.
+const digest64 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestParseDoesNotAddLibraryPrefix(t *testing.T) {
+	// Parse has no notion of which registry a bare name will ultimately
+	// be resolved against, so it must never apply the "library/"
+	// default itself; callers apply it once they know the effective
+	// registry.
+	ref, err := Parse("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Name != "ubuntu" {
+		t.Fatalf("Parse(%q).Name = %q, want %q", "ubuntu", ref.Name, "ubuntu")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"",
+		":tag",
+		"foo:",
+		"foo@sha256:nothex",
+		"foo@bogus",
+	}
+	for _, in := range cases {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) expected error, got none", in)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+
+	"github.com/blacktop/graboid/pkg/progress"
+)
+
+// downloadBlob fetches d into dir, returning its filename relative to
+// dir. When the registry's cacheDir is configured, the blob is fetched
+// into (and resumed from, and verified against) a persistent cache file
+// shared across invocations, then linked into dir; otherwise it is
+// fetched directly into dir with no resume support. bar may be nil.
+func (r *Registry) downloadBlob(dir, repoName string, d Descriptor, bar *progress.Bar) (string, error) {
+	fname := digestFilename(d.Digest)
+	destPath := filepath.Join(dir, fname)
+
+	workPath := destPath
+	if r.cacheDir != "" {
+		workPath = filepath.Join(r.cacheDir, "blobs", fname)
+		if err := os.MkdirAll(filepath.Dir(workPath), 0755); err != nil {
+			return "", err
+		}
+	}
+
+	if complete, err := digestMatches(workPath, d.Digest); err == nil && complete {
+		log.WithField("digest", d.Digest).Debug("blob already cached")
+		if bar != nil {
+			bar.SetTotal(d.Size)
+			if info, err := os.Stat(workPath); err == nil {
+				bar.Add(info.Size())
+			}
+			bar.Done("Already exists")
+		}
+	} else if err := r.fetchBlobInto(repoName, d, workPath, bar); err != nil {
+		return "", err
+	}
+
+	if workPath != destPath {
+		if err := linkOrCopy(workPath, destPath); err != nil {
+			return "", err
+		}
+	}
+
+	return fname, nil
+}
+
+// fetchBlobInto streams d from the registry into workPath, resuming from
+// any partial content already on disk, then verifies the complete file
+// against d's digest.
+func (r *Registry) fetchBlobInto(repoName string, d Descriptor, workPath string, bar *progress.Bar) error {
+	var resumeFrom int64
+	if info, err := os.Stat(workPath); err == nil {
+		resumeFrom = info.Size()
+	}
+
+	req, err := r.newRequest(http.MethodGet, r.url("/v2/%s/blobs/%s", repoName, d.Digest))
+	if err != nil {
+		return err
+	}
+	if resumeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+
+	log.WithField("digest", d.Digest).Debug("downloading blob")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var out *os.File
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		out, err = os.OpenFile(workPath, os.O_WRONLY|os.O_APPEND, 0644)
+	case http.StatusOK:
+		resumeFrom = 0 // server ignored our Range request; start over
+		out, err = os.Create(workPath)
+	default:
+		return fmt.Errorf("unexpected status %d fetching blob %s", resp.StatusCode, d.Digest)
+	}
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if bar != nil {
+		total := d.Size
+		if total <= 0 && resp.ContentLength >= 0 {
+			total = resumeFrom + resp.ContentLength
+		}
+		bar.SetTotal(total)
+		bar.Add(resumeFrom)
+	}
+
+	w := io.Writer(out)
+	if bar != nil {
+		w = progressWriter{w: w, bar: bar}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+
+	if complete, err := digestMatches(workPath, d.Digest); err != nil {
+		return err
+	} else if !complete {
+		os.Remove(workPath)
+		return fmt.Errorf("digest mismatch for blob %s after download", d.Digest)
+	}
+
+	if bar != nil {
+		bar.Done("Pull complete")
+	}
+	return nil
+}
+
+// digestMatches reports whether the file at path exists and its sha256
+// digest matches wantDigest.
+func digestMatches(path, wantDigest string) (bool, error) {
+	wantHex := stripSha256Prefix(wantDigest)
+	if wantHex == "" {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == wantHex, nil
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// progressWriter reports every write to a progress.Bar as it passes
+// through.
+type progressWriter struct {
+	w   io.Writer
+	bar *progress.Bar
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.bar.Add(int64(n))
+	return n, err
+}
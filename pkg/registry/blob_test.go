@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadBlobFetchesAndVerifies(t *testing.T) {
+	content := []byte("hello world")
+	d := Descriptor{Digest: digestOf(content), Size: int64(len(content))}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	dir := t.TempDir()
+
+	fname, err := r.downloadBlob(dir, "library/ubuntu", d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, fname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("downloaded content = %q, want %q", got, content)
+	}
+}
+
+func TestDownloadBlobRejectsDigestMismatch(t *testing.T) {
+	d := Descriptor{Digest: digestOf([]byte("expected")), Size: 5}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("wrong"))
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	dir := t.TempDir()
+
+	if _, err := r.downloadBlob(dir, "repo", d, nil); err == nil {
+		t.Fatal("expected a digest mismatch error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, digestFilename(d.Digest))); !os.IsNotExist(err) {
+		t.Error("a mismatched download should not leave a file behind")
+	}
+}
+
+func TestDownloadBlobResumesFromCache(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	d := Descriptor{Digest: digestOf(content), Size: int64(len(content))}
+	cacheDir := t.TempDir()
+
+	var gotRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotRange = req.Header.Get("Range")
+		if gotRange != "" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[8:])
+			return
+		}
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	r, err := New(Config{Endpoint: srv.URL, RegistryDomain: srv.URL, CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a prior partial download left in the cache.
+	workPath := filepath.Join(cacheDir, "blobs", digestFilename(d.Digest))
+	if err := os.MkdirAll(filepath.Dir(workPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workPath, content[:8], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	fname, err := r.downloadBlob(dir, "repo", d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotRange != "bytes=8-" {
+		t.Errorf("Range header = %q, want %q", gotRange, "bytes=8-")
+	}
+	got, err := os.ReadFile(filepath.Join(dir, fname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("resumed download = %q, want %q", got, content)
+	}
+}
+
+func TestDownloadBlobSkipsCachedComplete(t *testing.T) {
+	content := []byte("cached content")
+	d := Descriptor{Digest: digestOf(content), Size: int64(len(content))}
+	cacheDir := t.TempDir()
+
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	r, err := New(Config{Endpoint: srv.URL, RegistryDomain: srv.URL, CacheDir: cacheDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workPath := filepath.Join(cacheDir, "blobs", digestFilename(d.Digest))
+	if err := os.MkdirAll(filepath.Dir(workPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(workPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if _, err := r.downloadBlob(dir, "repo", d, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits != 0 {
+		t.Errorf("expected no network fetch for an already-cached, verified blob, got %d hit(s)", hits)
+	}
+}
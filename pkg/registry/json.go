@@ -0,0 +1,10 @@
+package registry
+
+import (
+	"encoding/json"
+	"io"
+)
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	return json.NewDecoder(r).Decode(v)
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blacktop/graboid/pkg/progress"
+)
+
+// progressRefresh is how often in-flight download bars are redrawn.
+const progressRefresh = 100 * time.Millisecond
+
+// RepoGetLayers downloads every layer blob referenced by m into dir,
+// oldest layer first, using up to r.maxConcurrentDownloads workers and
+// reporting a progress bar per layer. Layers already present and
+// verified in the registry's cache directory are skipped. It returns
+// each layer's filename relative to dir, in the same oldest-first order
+// as m.Layers regardless of the order downloads complete in.
+func (r *Registry) RepoGetLayers(dir, repoName string, m *Manifest) ([]string, error) {
+	layerFiles := make([]string, len(m.Layers))
+	errs := make([]error, len(m.Layers))
+
+	bars := progress.NewSet(os.Stderr)
+	defer bars.Close()
+
+	sem := make(chan struct{}, r.maxConcurrentDownloads)
+	var wg sync.WaitGroup
+
+	stopRefresh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(progressRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bars.Draw()
+			case <-stopRefresh:
+				return
+			}
+		}
+	}()
+	defer close(stopRefresh)
+
+	for i, layer := range m.Layers {
+		wg.Add(1)
+		go func(i int, layer Descriptor) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			bar := bars.NewBar(shortDigest(layer.Digest), layer.Size)
+			fname, err := r.downloadBlob(dir, repoName, layer, bar)
+			if err != nil {
+				bar.Done(fmt.Sprintf("error: %v", err))
+				errs[i] = fmt.Errorf("downloading layer %s: %v", layer.Digest, err)
+				return
+			}
+			layerFiles[i] = fname
+		}(i, layer)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return layerFiles, nil
+}
+
+// shortDigest trims a "sha256:"-prefixed digest down to the 12-character
+// form docker pull uses as a per-layer progress label.
+func shortDigest(digest string) string {
+	hex := stripSha256Prefix(digest)
+	if hex == "" {
+		return digest
+	}
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return hex
+}
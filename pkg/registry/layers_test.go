@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepoGetLayersPreservesOrder(t *testing.T) {
+	var layers []Descriptor
+	content := map[string][]byte{}
+	for i := 0; i < 4; i++ {
+		c := []byte(fmt.Sprintf("layer-%d-content", i))
+		d := Descriptor{Digest: digestOf(c), Size: int64(len(c))}
+		layers = append(layers, d)
+		content[d.Digest] = c
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(req.URL.Path, "/")
+		digest := parts[len(parts)-1]
+		// Stagger responses so the layers don't finish downloading in
+		// request order, to actually exercise out-of-order completion.
+		if digest != layers[len(layers)-1].Digest {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Write(content[digest])
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	dir := t.TempDir()
+	m := &Manifest{Layers: layers}
+
+	got, err := r.RepoGetLayers(dir, "repo", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, d := range layers {
+		want := digestFilename(d.Digest)
+		if got[i] != want {
+			t.Errorf("layer %d = %s, want %s (oldest-first order must be preserved regardless of download completion order)", i, got[i], want)
+		}
+	}
+}
+
+func TestRepoGetLayersPropagatesError(t *testing.T) {
+	layers := []Descriptor{{Digest: digestOf([]byte("a")), Size: 1}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	dir := t.TempDir()
+	m := &Manifest{Layers: layers}
+
+	if _, err := r.RepoGetLayers(dir, "repo", m); err == nil {
+		t.Fatal("expected an error when a layer fetch fails")
+	}
+}
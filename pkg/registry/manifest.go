@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+)
+
+// Media types understood by ReposManifests. Its Accept header lists them
+// index/list types first and schema 1 last.
+const (
+	MediaTypeDockerManifestV1   = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeDockerManifestV2   = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIImageIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// Platform identifies which child manifest to select from a manifest list
+// or OCI image index.
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// Descriptor references a single content-addressable blob (a config,
+// layer, or child manifest).
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+func (d Descriptor) matches(p Platform) bool {
+	if d.Platform == nil {
+		return false
+	}
+	if d.Platform.OS != p.OS || d.Platform.Architecture != p.Arch {
+		return false
+	}
+	if p.Variant != "" && d.Platform.Variant != p.Variant {
+		return false
+	}
+	return true
+}
+
+// manifestList is the wire format of both Docker's manifest list and the
+// OCI image index: a set of per-platform manifest descriptors.
+type manifestList struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []Descriptor `json:"manifests"`
+}
+
+// schema2Manifest is the wire format of Docker's schema 2 manifest and the
+// OCI image manifest: a config descriptor plus an ordered list of layer
+// descriptors.
+type schema2Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    Descriptor   `json:"config"`
+	Layers    []Descriptor `json:"layers"`
+}
+
+// FSLayer references a single layer blob by digest, as used in the legacy
+// Docker schema 1 manifest.
+type FSLayer struct {
+	BlobSum string `json:"blobSum"`
+}
+
+// schema1Manifest is the wire format of the legacy Docker schema 1
+// manifest, kept around for registries that still serve it.
+type schema1Manifest struct {
+	Name     string    `json:"name"`
+	Tag      string    `json:"tag"`
+	FSLayers []FSLayer `json:"fsLayers"`
+}
+
+// Manifest is the resolved, single-platform image manifest graboid
+// actually pulls config and layers from, regardless of which schema the
+// registry served it as.
+type Manifest struct {
+	MediaType string
+	Digest    string // sha256 digest of the raw manifest bytes, as fetched
+	Size      int64  // byte length of the raw manifest, as fetched
+	Config    Descriptor
+	Layers    []Descriptor // oldest layer first
+}
+
+// ReposManifests fetches the manifest for repoName:reference, resolving
+// manifest lists / OCI image indexes to a single-platform manifest that
+// matches platform.
+func (r *Registry) ReposManifests(repoName, reference string, platform Platform) (*Manifest, error) {
+	resp, err := r.get(r.url("/v2/%s/manifests/%s", repoName, reference),
+		MediaTypeOCIImageIndex, MediaTypeDockerManifestList,
+		MediaTypeOCIManifest, MediaTypeDockerManifestV2, MediaTypeDockerManifestV1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d fetching manifest for %s:%s", resp.StatusCode, repoName, reference)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest body: %v", err)
+	}
+
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	mediaType := resp.Header.Get("Content-Type")
+
+	switch mediaType {
+	case MediaTypeDockerManifestList, MediaTypeOCIImageIndex:
+		var list manifestList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("decoding manifest list: %v", err)
+		}
+		child, err := selectPlatform(list.Manifests, platform)
+		if err != nil {
+			return nil, err
+		}
+		log.WithField("digest", child.Digest).Infof("selected %s/%s manifest from list", platform.OS, platform.Arch)
+		m, err := r.ReposManifests(repoName, child.Digest, platform)
+		if err != nil {
+			return nil, err
+		}
+		// Callers (pull-by-digest, --trusted) verify against the digest
+		// they originally resolved the pull from, which for a manifest
+		// list or OCI image index is the list's own digest - not the
+		// per-platform manifest selected out of it. Keep that identity;
+		// only Config/Layers come from the child.
+		m.Digest = digest
+		m.Size = int64(len(body))
+		return m, nil
+
+	case MediaTypeDockerManifestV2, MediaTypeOCIManifest:
+		var m schema2Manifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("decoding schema 2 manifest: %v", err)
+		}
+		return &Manifest{MediaType: mediaType, Digest: digest, Size: int64(len(body)), Config: m.Config, Layers: m.Layers}, nil
+
+	default:
+		// Registries are not required to echo back a Content-Type we
+		// recognize (or any at all); fall back to the deprecated
+		// schema 1 format, which is what's left once the others are
+		// ruled out.
+		var m schema1Manifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("decoding schema 1 manifest: %v", err)
+		}
+		layers := make([]Descriptor, 0, len(m.FSLayers))
+		for i := len(m.FSLayers) - 1; i >= 0; i-- {
+			layers = append(layers, Descriptor{Digest: m.FSLayers[i].BlobSum})
+		}
+		return &Manifest{MediaType: MediaTypeDockerManifestV1, Digest: digest, Size: int64(len(body)), Layers: layers}, nil
+	}
+}
+
+func selectPlatform(manifests []Descriptor, platform Platform) (Descriptor, error) {
+	for _, d := range manifests {
+		if d.matches(platform) {
+			return d, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no manifest found for platform %s/%s (variant %q)", platform.OS, platform.Arch, platform.Variant)
+}
+
+// RepoGetConfig downloads the image config blob into dir and returns its
+// filename relative to dir. Schema 1 manifests carry no separate config
+// blob, so an empty placeholder is written instead.
+func (r *Registry) RepoGetConfig(dir, repoName string, m *Manifest) (string, error) {
+	if m.Config.Digest == "" {
+		confFile := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(confFile, []byte("{}"), 0644); err != nil {
+			return "", err
+		}
+		return filepath.Base(confFile), nil
+	}
+
+	fname, err := r.downloadBlob(dir, repoName, m.Config, nil)
+	if err != nil {
+		return "", fmt.Errorf("downloading config: %v", err)
+	}
+	return fname, nil
+}
+
+func stripSha256Prefix(digest string) string {
+	const prefix = "sha256:"
+	if len(digest) > len(prefix) && digest[:len(prefix)] == prefix {
+		return digest[len(prefix):]
+	}
+	return ""
+}
+
+func digestFilename(digest string) string {
+	return stripSha256Prefix(digest) + ".tar.gz"
+}
@@ -0,0 +1,175 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T, srv *httptest.Server) *Registry {
+	t.Helper()
+	r, err := New(Config{Endpoint: srv.URL, RegistryDomain: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestReposManifestsSchema2(t *testing.T) {
+	m := schema2Manifest{
+		MediaType: MediaTypeDockerManifestV2,
+		Config:    Descriptor{Digest: "sha256:" + hexDigest("config"), Size: 2},
+		Layers:    []Descriptor{{Digest: "sha256:" + hexDigest("layer0"), Size: 3}},
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", MediaTypeDockerManifestV2)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	got, err := r.ReposManifests("library/ubuntu", "latest", Platform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Digest != digestOf(body) {
+		t.Errorf("Digest = %s, want %s", got.Digest, digestOf(body))
+	}
+	if got.Size != int64(len(body)) {
+		t.Errorf("Size = %d, want %d", got.Size, len(body))
+	}
+	if got.Config.Digest != m.Config.Digest {
+		t.Errorf("Config.Digest = %s, want %s", got.Config.Digest, m.Config.Digest)
+	}
+	if len(got.Layers) != 1 || got.Layers[0].Digest != m.Layers[0].Digest {
+		t.Errorf("Layers = %+v, want %+v", got.Layers, m.Layers)
+	}
+}
+
+func TestReposManifestsSchema1Fallback(t *testing.T) {
+	m := schema1Manifest{
+		Name:     "library/ubuntu",
+		Tag:      "latest",
+		FSLayers: []FSLayer{{BlobSum: "sha256:" + hexDigest("top")}, {BlobSum: "sha256:" + hexDigest("bottom")}},
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// No recognized Content-Type: forces the schema 1 fallback path.
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	got, err := r.ReposManifests("library/ubuntu", "latest", Platform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.MediaType != MediaTypeDockerManifestV1 {
+		t.Errorf("MediaType = %s, want %s", got.MediaType, MediaTypeDockerManifestV1)
+	}
+	if got.Config.Digest != "" {
+		t.Errorf("expected no config digest for a schema 1 manifest, got %s", got.Config.Digest)
+	}
+	// schema1Manifest lists FSLayers top-first; ReposManifests must
+	// reverse them so Layers is oldest-first like every other schema.
+	if len(got.Layers) != 2 || got.Layers[0].Digest != m.FSLayers[1].BlobSum || got.Layers[1].Digest != m.FSLayers[0].BlobSum {
+		t.Errorf("Layers = %+v, want oldest-first reversal of %+v", got.Layers, m.FSLayers)
+	}
+}
+
+func TestReposManifestsListPreservesListDigest(t *testing.T) {
+	child := schema2Manifest{
+		MediaType: MediaTypeDockerManifestV2,
+		Config:    Descriptor{Digest: "sha256:" + hexDigest("config"), Size: 2},
+		Layers:    []Descriptor{{Digest: "sha256:" + hexDigest("layer0"), Size: 3}},
+	}
+	childBody, err := json.Marshal(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childDigest := digestOf(childBody)
+
+	list := manifestList{
+		MediaType: MediaTypeDockerManifestList,
+		Manifests: []Descriptor{{
+			MediaType: MediaTypeDockerManifestV2,
+			Digest:    childDigest,
+			Platform: &struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+				Variant      string `json:"variant,omitempty"`
+			}{Architecture: "amd64", OS: "linux"},
+		}},
+	}
+	listBody, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/library/ubuntu/manifests/"+childDigest {
+			w.Header().Set("Content-Type", MediaTypeDockerManifestV2)
+			w.Write(childBody)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeDockerManifestList)
+		w.Write(listBody)
+	}))
+	defer srv.Close()
+
+	r := newTestRegistry(t, srv)
+	got, err := r.ReposManifests("library/ubuntu", "latest", Platform{OS: "linux", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Manifest returned for a multi-arch tag must carry the LIST's
+	// digest/size, since that's what --trusted and pull-by-digest verify
+	// against - not the per-platform manifest selected out of it.
+	if got.Digest != digestOf(listBody) {
+		t.Errorf("Digest = %s, want the list digest %s (got child digest instead: %v)", got.Digest, digestOf(listBody), got.Digest == childDigest)
+	}
+	if got.Size != int64(len(listBody)) {
+		t.Errorf("Size = %d, want the list's size %d", got.Size, len(listBody))
+	}
+	if got.Config.Digest != child.Config.Digest {
+		t.Errorf("Config.Digest = %s, want the selected child's %s", got.Config.Digest, child.Config.Digest)
+	}
+}
+
+func TestSelectPlatformNoMatch(t *testing.T) {
+	manifests := []Descriptor{{
+		Platform: &struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+			Variant      string `json:"variant,omitempty"`
+		}{Architecture: "arm64", OS: "linux"},
+	}}
+	if _, err := selectPlatform(manifests, Platform{OS: "linux", Arch: "amd64"}); err == nil {
+		t.Fatal("expected an error when no manifest matches the requested platform")
+	}
+}
+
+func hexDigest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
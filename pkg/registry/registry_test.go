@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetTokenUsesChallengeRealmServiceAndRepoScope(t *testing.T) {
+	var gotService, gotScope string
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotService = req.URL.Query().Get("service")
+		gotScope = req.URL.Query().Get("scope")
+		w.Write([]byte(`{"token":"t0k3n"}`))
+	}))
+	defer authSrv.Close()
+
+	var challenge string
+	regSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if challenge == "" {
+			challenge = `Bearer realm="` + authSrv.URL + `/token",service="myregistry.example.org"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer regSrv.Close()
+
+	r, err := New(Config{Endpoint: regSrv.URL, RegistryDomain: regSrv.URL, RepoName: "library/ubuntu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.GetToken(); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotService != "myregistry.example.org" {
+		t.Errorf("service = %q, want %q (must come from the challenge, not a hardcoded Docker Hub value)", gotService, "myregistry.example.org")
+	}
+	if gotScope != "repository:library/ubuntu:pull" {
+		t.Errorf("scope = %q, want %q", gotScope, "repository:library/ubuntu:pull")
+	}
+	if r.token != "t0k3n" {
+		t.Errorf("token = %q, want %q", r.token, "t0k3n")
+	}
+}
+
+func TestGetTokenNoOpWhenRegistryDoesNotChallenge(t *testing.T) {
+	regSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer regSrv.Close()
+
+	r, err := New(Config{Endpoint: regSrv.URL, RegistryDomain: regSrv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.GetToken(); err != nil {
+		t.Fatal(err)
+	}
+	if r.token != "" {
+		t.Errorf("token = %q, want empty when the registry never challenged us", r.token)
+	}
+}
+
+func TestParseBearerChallengeIgnoresNonBearerSchemes(t *testing.T) {
+	c, err := parseBearerChallenge(`Basic realm="registry"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != nil {
+		t.Errorf("expected a nil challenge for a Basic scheme, got %+v", c)
+	}
+}
+
+func TestParseBearerChallengeRequiresRealm(t *testing.T) {
+	if _, err := parseBearerChallenge(`Bearer service="registry.docker.io"`); err == nil {
+		t.Fatal("expected an error for a Bearer challenge with no realm")
+	}
+}
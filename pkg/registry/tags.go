@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Tags is the response body of the repository tag listing endpoint.
+type Tags struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// ReposTags lists the tags available for repoName.
+func (r *Registry) ReposTags(repoName string) (*Tags, error) {
+	resp, err := r.get(r.url("/v2/%s/tags/list", repoName))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d listing tags for %s", resp.StatusCode, repoName)
+	}
+
+	var tags Tags
+	if err := decodeJSON(resp.Body, &tags); err != nil {
+		return nil, fmt.Errorf("decoding tags list: %v", err)
+	}
+	return &tags, nil
+}
@@ -0,0 +1,214 @@
+// Package trust implements just enough of The Update Framework (TUF), as
+// used by Docker Content Trust / Notary, to resolve a tag to a signed
+// manifest digest and size without a running notary-client or docker
+// daemon.
+package trust
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config configures a Client.
+type Config struct {
+	Server   string // e.g. https://notary.docker.io
+	RootFile string // pinned root.json, overrides CacheDir's copy
+	CacheDir string // e.g. ~/.graboid/trust
+	Insecure bool
+}
+
+// Client resolves tags against a TUF trust server.
+type Client struct {
+	cfg    Config
+	client *http.Client
+}
+
+// New creates a trust Client from cfg.
+func New(cfg Config) *Client {
+	transport := &http.Transport{}
+	if cfg.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &Client{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// Resolve verifies gun's trust metadata and returns the manifest digest
+// and byte size signed for tag.
+func (c *Client) Resolve(gun, tag string) (digest string, size int64, err error) {
+	root, err := c.loadRoot(gun)
+	if err != nil {
+		return "", 0, err
+	}
+
+	targets, err := c.fetchRole(gun, "targets")
+	if err != nil {
+		return "", 0, err
+	}
+	var targetsMeta targetsSigned
+	if err := json.Unmarshal(targets.Signed, &targetsMeta); err != nil {
+		return "", 0, fmt.Errorf("trust server returned malformed response")
+	}
+	targetsRole, ok := root.Roles["targets"]
+	if !ok {
+		return "", 0, fmt.Errorf("root metadata for %s has no targets role", gun)
+	}
+	if err := verifyThreshold(targets, root.Keys, targetsRole.KeyIDs, targetsRole.Threshold); err != nil {
+		return "", 0, fmt.Errorf("targets metadata for %s: %v", gun, err)
+	}
+	if err := checkExpiry(gun, "targets", targetsMeta.Expires); err != nil {
+		return "", 0, err
+	}
+
+	if t, ok := targetsMeta.Targets[tag]; ok {
+		return hashAndSize(t)
+	}
+
+	if targetsMeta.Delegations != nil {
+		for _, d := range targetsMeta.Delegations.Roles {
+			delegated, err := c.fetchRole(gun, d.Name)
+			if err != nil {
+				continue // delegated roles are optional; skip ones we can't reach
+			}
+			var delegatedMeta targetsSigned
+			if err := json.Unmarshal(delegated.Signed, &delegatedMeta); err != nil {
+				return "", 0, fmt.Errorf("trust server returned malformed response")
+			}
+			keys := targetsMeta.Delegations.Keys
+			if err := verifyThreshold(delegated, keys, d.KeyIDs, d.Threshold); err != nil {
+				continue
+			}
+			if err := checkExpiry(gun, d.Name, delegatedMeta.Expires); err != nil {
+				return "", 0, err
+			}
+			if t, ok := delegatedMeta.Targets[tag]; ok {
+				return hashAndSize(t)
+			}
+		}
+	}
+
+	return "", 0, fmt.Errorf("%s: tag %q is not signed in the trust metadata", gun, tag)
+}
+
+func hashAndSize(t targetFile) (string, int64, error) {
+	sum, ok := t.Hashes["sha256"]
+	if !ok {
+		return "", 0, fmt.Errorf("signed target has no sha256 hash")
+	}
+	return "sha256:" + sum, t.Length, nil
+}
+
+func checkExpiry(gun, role string, expires time.Time) error {
+	if time.Now().After(expires) {
+		return fmt.Errorf("trust metadata for %s (%s role) expired on %s; the repository's signers need to publish fresh metadata",
+			gun, role, expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadRoot fetches and verifies root.json, pinning it to disk on first
+// use and requiring that subsequent fetches are signed by the previously
+// pinned root's keys.
+func (c *Client) loadRoot(gun string) (*rootSigned, error) {
+	pinnedPath := c.rootPath(gun)
+
+	fetched, err := c.fetchRole(gun, "root")
+	if err != nil {
+		return nil, err
+	}
+	var fetchedRoot rootSigned
+	if err := json.Unmarshal(fetched.Signed, &fetchedRoot); err != nil {
+		return nil, fmt.Errorf("trust server returned malformed response")
+	}
+	rootRole, ok := fetchedRoot.Roles["root"]
+	if !ok {
+		return nil, fmt.Errorf("root metadata for %s has no root role", gun)
+	}
+
+	if pinned, err := loadPinnedRoot(pinnedPath); err == nil {
+		if verr := verifyThreshold(fetched, pinned.Keys, pinned.Roles["root"].KeyIDs, pinned.Roles["root"].Threshold); verr != nil {
+			return nil, fmt.Errorf("fetched root metadata for %s is not signed by the pinned trust root: %v", gun, verr)
+		}
+	} else {
+		// Trust-on-first-use: the root must at least be self-consistent.
+		if verr := verifyThreshold(fetched, fetchedRoot.Keys, rootRole.KeyIDs, rootRole.Threshold); verr != nil {
+			return nil, fmt.Errorf("root metadata for %s: %v", gun, verr)
+		}
+	}
+
+	if err := checkExpiry(gun, "root", fetchedRoot.Expires); err != nil {
+		return nil, err
+	}
+
+	if err := c.pinRoot(pinnedPath, fetched); err != nil {
+		return nil, fmt.Errorf("caching trust root: %v", err)
+	}
+
+	return &fetchedRoot, nil
+}
+
+func loadPinnedRoot(path string) (*rootSigned, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var env signed
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	var root rootSigned
+	if err := json.Unmarshal(env.Signed, &root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+func (c *Client) rootPath(gun string) string {
+	if c.cfg.RootFile != "" {
+		return c.cfg.RootFile
+	}
+	return filepath.Join(c.cfg.CacheDir, gun, "root.json")
+}
+
+func (c *Client) pinRoot(path string, env signed) error {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+func (c *Client) fetchRole(gun, role string) (signed, error) {
+	url := fmt.Sprintf("%s/v2/%s/_trust/tuf/%s.json", c.cfg.Server, gun, role)
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return signed{}, fmt.Errorf("fetching %s metadata: %v", role, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return signed{}, fmt.Errorf("trust server returned status %d fetching %s metadata", resp.StatusCode, role)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return signed{}, fmt.Errorf("reading %s metadata: %v", role, err)
+	}
+
+	var env signed
+	if err := json.Unmarshal(body, &env); err != nil {
+		return signed{}, fmt.Errorf("trust server returned malformed response")
+	}
+	return env, nil
+}
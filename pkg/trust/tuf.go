@@ -0,0 +1,73 @@
+package trust
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// signed is the generic TUF envelope: a signed payload plus the
+// signatures over its canonical JSON encoding.
+type signed struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []signature     `json:"signatures"`
+}
+
+type signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// key is a TUF public key, as embedded in root.json and delegations.
+type key struct {
+	KeyType string `json:"keytype"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+// role names the keys trusted for a TUF role and how many of them must
+// sign for that role's metadata to be considered valid.
+type role struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// rootSigned is the "signed" half of root.json: the full set of keys and
+// role->key bindings for this repository.
+type rootSigned struct {
+	Type    string          `json:"_type"`
+	Version int             `json:"version"`
+	Expires time.Time       `json:"expires"`
+	Keys    map[string]key  `json:"keys"`
+	Roles   map[string]role `json:"roles"`
+}
+
+// delegation is a single delegated targets role, e.g. "targets/releases".
+type delegation struct {
+	Name      string   `json:"name"`
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+	Paths     []string `json:"paths"`
+}
+
+type delegations struct {
+	Keys  map[string]key `json:"keys"`
+	Roles []delegation   `json:"roles"`
+}
+
+// targetFile describes a single signed target (here, always a tag ->
+// manifest digest/size pair).
+type targetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// targetsSigned is the "signed" half of targets.json (and any delegated
+// targets role sharing the same shape).
+type targetsSigned struct {
+	Type        string                `json:"_type"`
+	Version     int                   `json:"version"`
+	Expires     time.Time             `json:"expires"`
+	Targets     map[string]targetFile `json:"targets"`
+	Delegations *delegations          `json:"delegations,omitempty"`
+}
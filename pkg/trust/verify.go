@@ -0,0 +1,216 @@
+package trust
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// canonicalJSON re-encodes arbitrary JSON with map keys sorted and all
+// insignificant whitespace removed, matching the encoding TUF signs over.
+func canonicalJSON(raw json.RawMessage) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return encodeCanonical(v)
+}
+
+func encodeCanonical(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		out := []byte{'{'}
+		for i, k := range keys {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			kb, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, kb...)
+			out = append(out, ':')
+			vb, err := encodeCanonical(val[k])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, vb...)
+		}
+		out = append(out, '}')
+		return out, nil
+
+	case []interface{}:
+		out := []byte{'['}
+		for i, e := range val {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			eb, err := encodeCanonical(e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, eb...)
+		}
+		out = append(out, ']')
+		return out, nil
+
+	default:
+		return json.Marshal(val)
+	}
+}
+
+// verifyThreshold checks that at least threshold distinct trusted keyIDs
+// produced a valid signature over env's canonical signed payload. Real
+// Notary/DCT roots and targets are signed overwhelmingly with RSA or
+// ECDSA keys, not ed25519, so all three are supported.
+func verifyThreshold(env signed, keys map[string]key, keyIDs []string, threshold int) error {
+	canon, err := canonicalJSON(env.Signed)
+	if err != nil {
+		return fmt.Errorf("canonicalizing signed payload: %v", err)
+	}
+
+	trusted := make(map[string]bool, len(keyIDs))
+	for _, id := range keyIDs {
+		trusted[id] = true
+	}
+
+	valid := make(map[string]bool)
+	for _, sig := range env.Signatures {
+		if !trusted[sig.KeyID] {
+			continue
+		}
+		k, ok := keys[sig.KeyID]
+		if !ok {
+			continue
+		}
+		if verifySignature(k, sig, canon) {
+			valid[sig.KeyID] = true
+		}
+	}
+
+	if len(valid) < threshold {
+		return fmt.Errorf("signature threshold not met: got %d of %d required valid signatures", len(valid), threshold)
+	}
+	return nil
+}
+
+// verifySignature checks a single signature against k, dispatching on
+// the key's TUF keytype.
+func verifySignature(k key, sig signature, canon []byte) bool {
+	switch k.KeyType {
+	case "ed25519":
+		return verifyEd25519(k, sig, canon)
+	case "rsa", "rsa-x509":
+		return verifyRSA(k, sig, canon)
+	case "ecdsa", "ecdsa-x509":
+		return verifyECDSA(k, sig, canon)
+	default:
+		return false
+	}
+}
+
+func verifyEd25519(k key, sig signature, canon []byte) bool {
+	pub, err := hex.DecodeString(k.KeyVal.Public)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sigBytes, err := hex.DecodeString(sig.Sig)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(pub), canon, sigBytes)
+}
+
+func verifyRSA(k key, sig signature, canon []byte) bool {
+	pub, err := parsePublicKey(k.KeyVal.Public)
+	if err != nil {
+		return false
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	sigBytes, err := decodeSignature(sig.Sig)
+	if err != nil {
+		return false
+	}
+	hashed := sha256.Sum256(canon)
+	return rsa.VerifyPSS(rsaKey, crypto.SHA256, hashed[:], sigBytes, nil) == nil
+}
+
+func verifyECDSA(k key, sig signature, canon []byte) bool {
+	pub, err := parsePublicKey(k.KeyVal.Public)
+	if err != nil {
+		return false
+	}
+	ecKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	sigBytes, err := decodeSignature(sig.Sig)
+	if err != nil {
+		return false
+	}
+	hashed := sha256.Sum256(canon)
+
+	// Notary/DCT encode ECDSA signatures as the raw, fixed-width
+	// concatenation of r and s rather than an ASN.1 sequence.
+	byteLen := (ecKey.Curve.Params().BitSize + 7) / 8
+	if len(sigBytes) != 2*byteLen {
+		return false
+	}
+	r := new(big.Int).SetBytes(sigBytes[:byteLen])
+	s := new(big.Int).SetBytes(sigBytes[byteLen:])
+	return ecdsa.Verify(ecKey, hashed[:], r, s)
+}
+
+// parsePublicKey decodes a TUF key's "public" field, accepting a PEM
+// block (used by the "*-x509" keytypes, either a certificate or a bare
+// SubjectPublicKeyInfo) or raw base64-encoded DER, as Notary serves both
+// depending on how the key was issued.
+func parsePublicKey(raw string) (interface{}, error) {
+	der := []byte(raw)
+	if block, _ := pem.Decode([]byte(raw)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("public key is neither PEM nor base64: %v", err)
+		}
+		der = decoded
+	}
+
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+// decodeSignature accepts either hex or base64 signature encodings:
+// Notary's wire format differs by keytype, and being liberal here costs
+// nothing since the subsequent cryptographic verification is what
+// actually gates trust.
+func decodeSignature(s string) ([]byte, error) {
+	// Hex is tried first: a hex string whose length is a multiple of 4 is
+	// also valid base64 and would otherwise be mis-decoded.
+	if b, err := hex.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,134 @@
+package trust
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalJSONSortsKeys(t *testing.T) {
+	raw := json.RawMessage(`{"b":1,"a":2,"nested":{"z":1,"y":2}}`)
+	got, err := canonicalJSON(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":2,"b":1,"nested":{"y":2,"z":1}}`
+	if string(got) != want {
+		t.Fatalf("canonicalJSON = %s, want %s", got, want)
+	}
+}
+
+func TestVerifyThresholdEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := signed{Signed: json.RawMessage(`{"a":1}`)}
+	canon, err := canonicalJSON(env.Signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(priv, canon)
+	env.Signatures = []signature{{KeyID: "key1", Sig: hex.EncodeToString(sig)}}
+
+	keys := map[string]key{"key1": {KeyType: "ed25519", KeyVal: struct {
+		Public string `json:"public"`
+	}{Public: hex.EncodeToString(pub)}}}
+
+	if err := verifyThreshold(env, keys, []string{"key1"}, 1); err != nil {
+		t.Fatalf("expected valid signature to verify, got: %v", err)
+	}
+}
+
+func TestVerifyThresholdRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := signed{Signed: json.RawMessage(`{"a":1}`)}
+	canon, err := canonicalJSON(env.Signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(canon)
+	sig, err := rsa.SignPSS(rand.Reader, priv, crypto.SHA256, hashed[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Signatures = []signature{{KeyID: "key1", Sig: base64.StdEncoding.EncodeToString(sig)}}
+
+	keys := map[string]key{"key1": {KeyType: "rsa", KeyVal: struct {
+		Public string `json:"public"`
+	}{Public: base64.StdEncoding.EncodeToString(der)}}}
+
+	if err := verifyThreshold(env, keys, []string{"key1"}, 1); err != nil {
+		t.Fatalf("expected valid RSA signature to verify, got: %v", err)
+	}
+}
+
+func TestVerifyThresholdECDSA(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := signed{Signed: json.RawMessage(`{"a":1}`)}
+	canon, err := canonicalJSON(env.Signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(canon)
+	r, s, err := ecdsa.Sign(rand.Reader, priv, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	byteLen := (priv.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*byteLen)
+	r.FillBytes(sig[:byteLen])
+	s.FillBytes(sig[byteLen:])
+
+	env.Signatures = []signature{{KeyID: "key1", Sig: base64.StdEncoding.EncodeToString(sig)}}
+
+	keys := map[string]key{"key1": {KeyType: "ecdsa", KeyVal: struct {
+		Public string `json:"public"`
+	}{Public: base64.StdEncoding.EncodeToString(der)}}}
+
+	if err := verifyThreshold(env, keys, []string{"key1"}, 1); err != nil {
+		t.Fatalf("expected valid ECDSA signature to verify, got: %v", err)
+	}
+}
+
+func TestVerifyThresholdNotMet(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := signed{Signed: json.RawMessage(`{"a":1}`)}
+	keys := map[string]key{"key1": {KeyType: "ed25519", KeyVal: struct {
+		Public string `json:"public"`
+	}{Public: hex.EncodeToString(pub)}}}
+
+	// No signatures at all: threshold 1 must fail.
+	if err := verifyThreshold(env, keys, []string{"key1"}, 1); err == nil {
+		t.Fatal("expected error when no signatures are present")
+	}
+}